@@ -0,0 +1,90 @@
+// Copyright for portions of this fork are held by [Juan Batiz-Benet, 2016]
+// as part of the original go-datastore project. All other copyright for this
+// fork are held by [DAOT Labs, 2020]. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package keytransform
+
+import (
+	key "github.com/daotl/go-datastore/key"
+)
+
+// isOrderPreserving reports whether t is known to preserve the
+// lexicographic ordering of keys, either because it's a PrefixTransform or
+// because it implements OrderPreservingKeyTransform and says so.
+func isOrderPreserving(t KeyTransform) bool {
+	switch t := t.(type) {
+	case PrefixTransform, *PrefixTransform:
+		return true
+	case OrderPreservingKeyTransform:
+		return t.OrderPreserving()
+	}
+	return false
+}
+
+// Compose returns a KeyTransform that chains ts together: ConvertKey applies
+// them left-to-right, InvertKey undoes them right-to-left. This lets callers
+// layer transforms, e.g. a namespace prefix with a hash-based sharding
+// scheme, without hand-writing a bespoke type for the combination. The
+// result implements OrderPreservingKeyTransform, reporting order-preserving
+// only if every component does.
+func Compose(ts ...KeyTransform) KeyTransform {
+	return composedTransform(ts)
+}
+
+type composedTransform []KeyTransform
+
+func (c composedTransform) ConvertKey(k key.Key) key.Key {
+	for _, t := range c {
+		k = t.ConvertKey(k)
+	}
+	return k
+}
+
+func (c composedTransform) InvertKey(k key.Key) key.Key {
+	for i := len(c) - 1; i >= 0; i-- {
+		k = c[i].InvertKey(k)
+	}
+	return k
+}
+
+func (c composedTransform) OrderPreserving() bool {
+	for _, t := range c {
+		if !isOrderPreserving(t) {
+			return false
+		}
+	}
+	return true
+}
+
+var _ KeyTransform = composedTransform(nil)
+var _ OrderPreservingKeyTransform = composedTransform(nil)
+
+// Bijection builds a KeyTransform out of a pair of mutually inverse
+// KeyMapping functions, letting callers construct ad hoc, reversible key
+// mappings (e.g. a reversible encoding, a hash-based sharding scheme) without
+// hand-writing a bespoke type for each one.
+type Bijection struct {
+	Forward KeyMapping
+	Inverse KeyMapping
+
+	// Ordered marks the bijection as order-preserving, i.e. Forward must
+	// preserve the lexicographic ordering of keys. It's surfaced through
+	// OrderPreserving for prepareQuery's query-pushdown logic.
+	Ordered bool
+}
+
+func (b Bijection) ConvertKey(k key.Key) key.Key {
+	return b.Forward(k)
+}
+
+func (b Bijection) InvertKey(k key.Key) key.Key {
+	return b.Inverse(k)
+}
+
+func (b Bijection) OrderPreserving() bool {
+	return b.Ordered
+}
+
+var _ KeyTransform = Bijection{}
+var _ OrderPreservingKeyTransform = Bijection{}
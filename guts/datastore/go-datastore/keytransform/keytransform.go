@@ -13,6 +13,18 @@ import (
 	dsq "github.com/daotl/go-datastore/query"
 )
 
+// OrderPreservingKeyTransform is an optional interface that a KeyTransform
+// may implement to declare whether it preserves the lexicographic ordering
+// of keys. prepareQuery treats any transform satisfying this interface (and
+// whose OrderPreserving method returns true) the same way it treats
+// PrefixTransform: OrderByKey/OrderByKeyDescending, along with Offset and
+// Limit, can be delegated to the child datastore instead of being applied
+// naively in memory.
+type OrderPreservingKeyTransform interface {
+	KeyTransform
+	OrderPreserving() bool
+}
+
 // Wrap wraps a given datastore with a KeyTransform function.
 // The resulting wrapped datastore will use the transform on all Datastore
 // operations.
@@ -74,7 +86,7 @@ func (d *Datastore) Delete(ctx context.Context, key key.Key) (err error) {
 
 // Query implements Query, inverting keys on the way back out.
 func (d *Datastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
-	nq, cq := d.prepareQuery(q)
+	nq, cq := prepareQuery(d.KeyTransform, q)
 
 	cqr, err := d.child.Query(ctx, cq)
 	if err != nil {
@@ -101,33 +113,32 @@ func (d *Datastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error)
 
 // Split the query into a child query and a naive query. That way, we can make
 // the child datastore do as much work as possible.
-func (d *Datastore) prepareQuery(q dsq.Query) (naive, child dsq.Query) {
+func prepareQuery(t KeyTransform, q dsq.Query) (naive, child dsq.Query) {
 
 	// First, put everything in the child query. Then, start taking things
 	// out.
 	child = q
 
 	// Always let the child handle the key prefix.
-	child.Prefix = d.ConvertKey(key.Clean(child.Prefix))
+	child.Prefix = t.ConvertKey(key.Clean(child.Prefix))
 
 	// Always let the child handle the key range.
 	child.Range = dsq.Range{}
 	if child.Range.Start != nil {
-		child.Range.Start = d.ConvertKey(child.Range.Start)
+		child.Range.Start = t.ConvertKey(child.Range.Start)
 	}
 	if child.Range.End != nil {
-		child.Range.End = d.ConvertKey(child.Range.End)
+		child.Range.End = t.ConvertKey(child.Range.End)
 	}
 
 	// Check if the key transform is order-preserving so we can use the
 	// child datastore's built-in ordering.
-	orderPreserving := false
-	switch d.KeyTransform.(type) {
-	case PrefixTransform, *PrefixTransform:
-		orderPreserving = true
-	}
+	orderPreserving := isOrderPreserving(t)
 
-	// Try to let the child handle ordering.
+	// Try to let the child handle ordering. ordersDelegated tracks whether
+	// the child can apply every requested order itself; if not, we fall
+	// back to naive in-memory ordering.
+	ordersDelegated := true
 orders:
 	for i, o := range child.Orders {
 		switch o.(type) {
@@ -152,16 +163,15 @@ orders:
 
 		// Can't handle this order under transform, punt it to a naive
 		// ordering.
+		ordersDelegated = false
 		naive.Orders = q.Orders
 		child.Orders = nil
-		naive.Offset = q.Offset
-		child.Offset = 0
-		naive.Limit = q.Limit
-		child.Limit = 0
 		break
 	}
 
-	// Try to let the child handle the filters.
+	// Try to let the child handle the filters. filtersDelegated tracks
+	// whether the child can apply every requested filter itself.
+	filtersDelegated := true
 
 	// don't modify the original filters.
 	child.Filters = append([]dsq.Filter(nil), child.Filters...)
@@ -173,35 +183,42 @@ orders:
 		case dsq.FilterKeyCompare:
 			child.Filters[i] = dsq.FilterKeyCompare{
 				Op:  f.Op,
-				Key: d.ConvertKey(f.Key),
+				Key: t.ConvertKey(f.Key),
 			}
 			continue
 		case *dsq.FilterKeyCompare:
 			child.Filters[i] = &dsq.FilterKeyCompare{
 				Op:  f.Op,
-				Key: d.ConvertKey(f.Key),
+				Key: t.ConvertKey(f.Key),
 			}
 			continue
 		case dsq.FilterKeyPrefix:
 			child.Filters[i] = dsq.FilterKeyPrefix{
-				Prefix: d.ConvertKey(f.Prefix),
+				Prefix: t.ConvertKey(f.Prefix),
 			}
 			continue
 		case *dsq.FilterKeyPrefix:
 			child.Filters[i] = &dsq.FilterKeyPrefix{
-				Prefix: d.ConvertKey(f.Prefix),
+				Prefix: t.ConvertKey(f.Prefix),
 			}
 			continue
 		}
 
 		// Not a known filter, defer to the naive implementation.
+		filtersDelegated = false
 		naive.Filters = q.Filters
 		child.Filters = nil
+		break
+	}
+
+	// Offset/Limit are only safe to push down to the child when both
+	// orders and filters are fully delegated; otherwise the naive layer
+	// needs the full, unsliced result set to apply them itself.
+	if !ordersDelegated || !filtersDelegated {
 		naive.Offset = q.Offset
 		child.Offset = 0
 		naive.Limit = q.Limit
 		child.Limit = 0
-		break
 	}
 	return
 }
@@ -0,0 +1,134 @@
+// Copyright for portions of this fork are held by [Juan Batiz-Benet, 2016]
+// as part of the original go-datastore project. All other copyright for this
+// fork are held by [DAOT Labs, 2020]. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package keytransform
+
+import (
+	"context"
+	"testing"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// queryRecorder is a no-op datastore that records the last query it
+// received, so tests can assert on what prepareQuery decided to push down.
+type queryRecorder struct {
+	gotQuery dsq.Query
+	entries  []dsq.Entry
+}
+
+func (m *queryRecorder) Put(ctx context.Context, k key.Key, value []byte) error {
+	return nil
+}
+
+func (m *queryRecorder) Sync(ctx context.Context, prefix key.Key) error {
+	return nil
+}
+
+func (m *queryRecorder) Get(ctx context.Context, k key.Key) ([]byte, error) {
+	return nil, ds.ErrNotFound
+}
+
+func (m *queryRecorder) Has(ctx context.Context, k key.Key) (bool, error) {
+	return false, nil
+}
+
+func (m *queryRecorder) GetSize(ctx context.Context, k key.Key) (int, error) {
+	return -1, ds.ErrNotFound
+}
+
+func (m *queryRecorder) Delete(ctx context.Context, k key.Key) error {
+	return nil
+}
+
+func (m *queryRecorder) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	m.gotQuery = q
+	return dsq.ResultsWithEntries(q, m.entries), nil
+}
+
+func (m *queryRecorder) Close() error {
+	return nil
+}
+
+var _ ds.Datastore = (*queryRecorder)(nil)
+
+func TestPrepareQueryPushesDownOffsetLimitWhenFullyDelegated(t *testing.T) {
+	child := &queryRecorder{}
+	d := Wrap(child, PrefixTransform{Prefix: key.NewKey("/prefix")})
+
+	_, err := d.Query(context.Background(), dsq.Query{
+		Orders: []dsq.Order{dsq.OrderByKey{}},
+		Offset: 5,
+		Limit:  10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if child.gotQuery.Offset != 5 {
+		t.Errorf("expected offset to be pushed down to the child, got %d", child.gotQuery.Offset)
+	}
+	if child.gotQuery.Limit != 10 {
+		t.Errorf("expected limit to be pushed down to the child, got %d", child.gotQuery.Limit)
+	}
+}
+
+func TestPrepareQueryKeepsOffsetLimitNaiveWhenOrdersArentDelegated(t *testing.T) {
+	child := &queryRecorder{}
+	// A non-order-preserving transform forces OrderByKey to punt to naive
+	// ordering, which is the case this test means to exercise.
+	notOrderPreserving := Bijection{
+		Forward: func(k key.Key) key.Key { return k },
+		Inverse: func(k key.Key) key.Key { return k },
+	}
+	d := Wrap(child, notOrderPreserving)
+
+	_, err := d.Query(context.Background(), dsq.Query{
+		Orders: []dsq.Order{dsq.OrderByKey{}},
+		Offset: 5,
+		Limit:  10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if child.gotQuery.Offset != 0 {
+		t.Errorf("expected offset to be withheld from the child, got %d", child.gotQuery.Offset)
+	}
+	if child.gotQuery.Limit != 0 {
+		t.Errorf("expected limit to be withheld from the child, got %d", child.gotQuery.Limit)
+	}
+}
+
+func TestPrepareQueryKeepsOffsetLimitNaiveWhenFiltersArentDelegated(t *testing.T) {
+	child := &queryRecorder{}
+	d := Wrap(child, PrefixTransform{Prefix: key.NewKey("/prefix")})
+
+	_, err := d.Query(context.Background(), dsq.Query{
+		Filters: []dsq.Filter{notDelegableFilter{}},
+		Offset:  5,
+		Limit:   10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if child.gotQuery.Offset != 0 {
+		t.Errorf("expected offset to be withheld from the child, got %d", child.gotQuery.Offset)
+	}
+	if child.gotQuery.Limit != 0 {
+		t.Errorf("expected limit to be withheld from the child, got %d", child.gotQuery.Limit)
+	}
+}
+
+// notDelegableFilter is a Filter implementation that prepareQuery doesn't
+// know how to translate, forcing it to fall back to naive filtering.
+type notDelegableFilter struct{}
+
+func (notDelegableFilter) Filter(e dsq.Entry) bool {
+	return true
+}
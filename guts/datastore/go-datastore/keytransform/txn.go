@@ -0,0 +1,130 @@
+// Copyright for portions of this fork are held by [Juan Batiz-Benet, 2016]
+// as part of the original go-datastore project. All other copyright for this
+// fork are held by [DAOT Labs, 2020]. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package keytransform
+
+import (
+	"context"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// WrapTxnDatastore wraps a given transactional datastore with a KeyTransform
+// function.
+func WrapTxnDatastore(child ds.TxnDatastore, t KeyTransform) *TxnDatastore {
+	if t == nil {
+		panic("t (KeyTransform) is nil")
+	}
+
+	if child == nil {
+		panic("child (ds.TxnDatastore) is nil")
+	}
+
+	return &TxnDatastore{
+		Datastore: Wrap(child, t),
+		child:     child,
+	}
+}
+
+// TxnDatastore keeps a KeyTransform function and wraps a ds.TxnDatastore,
+// additionally allowing new transactions to be started against the
+// underlying child with keys transformed on the way in and out.
+type TxnDatastore struct {
+	*Datastore
+
+	child ds.TxnDatastore
+}
+
+// NewTransaction starts a new transaction against the child datastore,
+// transforming keys on the way in and inverting them on the way out.
+func (d *TxnDatastore) NewTransaction(ctx context.Context, readOnly bool) (ds.Txn, error) {
+	childTxn, err := d.child.NewTransaction(ctx, readOnly)
+	if err != nil {
+		return nil, err
+	}
+	return &txnWrapper{
+		child:        childTxn,
+		KeyTransform: d.KeyTransform,
+	}, nil
+}
+
+var _ ds.TxnDatastore = (*TxnDatastore)(nil)
+
+// txnWrapper transforms keys on the way in and inverts them on the way out of
+// a wrapped ds.Txn, mirroring the behavior of Datastore for the duration of a
+// transaction.
+type txnWrapper struct {
+	child ds.Txn
+
+	KeyTransform
+}
+
+// Put stores the given value, transforming the key first.
+func (t *txnWrapper) Put(ctx context.Context, k key.Key, value []byte) error {
+	return t.child.Put(ctx, t.ConvertKey(k), value)
+}
+
+// Get returns the value for given key, transforming the key first.
+func (t *txnWrapper) Get(ctx context.Context, k key.Key) (value []byte, err error) {
+	return t.child.Get(ctx, t.ConvertKey(k))
+}
+
+// Has returns whether the datastore has a value for a given key, transforming
+// the key first.
+func (t *txnWrapper) Has(ctx context.Context, k key.Key) (exists bool, err error) {
+	return t.child.Has(ctx, t.ConvertKey(k))
+}
+
+// GetSize returns the size of the value named by the given key, transforming
+// the key first.
+func (t *txnWrapper) GetSize(ctx context.Context, k key.Key) (size int, err error) {
+	return t.child.GetSize(ctx, t.ConvertKey(k))
+}
+
+// Delete removes the value for given key, transforming the key first.
+func (t *txnWrapper) Delete(ctx context.Context, k key.Key) error {
+	return t.child.Delete(ctx, t.ConvertKey(k))
+}
+
+// Query implements Query, inverting keys on the way back out.
+func (t *txnWrapper) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	nq, cq := prepareQuery(t.KeyTransform, q)
+
+	cqr, err := t.child.Query(ctx, cq)
+	if err != nil {
+		return nil, err
+	}
+
+	qr := dsq.ResultsFromIterator(q, dsq.Iterator{
+		Next: func() (dsq.Result, bool) {
+			r, ok := cqr.NextSync()
+			if !ok {
+				return r, false
+			}
+			if r.Error == nil {
+				r.Entry.Key = t.InvertKey(r.Entry.Key)
+			}
+			return r, true
+		},
+		Close: func() error {
+			return cqr.Close()
+		},
+	})
+	return dsq.NaiveQueryApply(nq, qr), nil
+}
+
+// Commit forwards the commit to the child transaction unchanged.
+func (t *txnWrapper) Commit(ctx context.Context) error {
+	return t.child.Commit(ctx)
+}
+
+// Discard forwards the discard to the child transaction unchanged.
+func (t *txnWrapper) Discard(ctx context.Context) error {
+	return t.child.Discard(ctx)
+}
+
+var _ ds.Txn = (*txnWrapper)(nil)
@@ -0,0 +1,393 @@
+// Copyright for portions of this fork are held by [Juan Batiz-Benet, 2016]
+// as part of the original go-datastore project. All other copyright for this
+// fork are held by [DAOT Labs, 2020]. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+// Package mount provides a Datastore that multiplexes several child
+// datastores across distinct, possibly nested, key prefixes.
+package mount
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	ds "github.com/daotl/go-datastore"
+	"github.com/daotl/go-datastore/keytransform"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// ErrNoMount is returned when no mount's prefix matches a given key.
+var ErrNoMount = errors.New("mount: no datastore mounted for this key")
+
+// Mount pairs a key prefix with the datastore that should serve all keys
+// under it.
+type Mount struct {
+	Prefix    key.Key
+	Datastore ds.Datastore
+}
+
+// mountpoint is the resolved, ready-to-route form of a Mount: its prefix and
+// the PrefixTransform used to translate between the full, mount-relative key
+// space and the child datastore's own, unprefixed key space.
+type mountpoint struct {
+	prefix key.Key
+	t      keytransform.PrefixTransform
+	store  ds.Datastore
+}
+
+// toLocal strips the mount's prefix from a full key, producing the key the
+// child datastore knows about.
+func (m *mountpoint) toLocal(k key.Key) key.Key {
+	return m.t.InvertKey(k)
+}
+
+// toGlobal re-prepends the mount's prefix to a key local to the child
+// datastore, producing the key seen at the Datastore level.
+func (m *mountpoint) toGlobal(k key.Key) key.Key {
+	return m.t.ConvertKey(k)
+}
+
+// under reports whether k is the mount's prefix itself or a descendant of it.
+func (m *mountpoint) under(k key.Key) bool {
+	ks, ps := k.String(), m.prefix.String()
+	if ps == "/" {
+		return true
+	}
+	return ks == ps || strings.HasPrefix(ks, ps+"/")
+}
+
+// intersects reports whether the mount's prefix and k overlap, i.e. one is
+// an ancestor of (or equal to) the other.
+func (m *mountpoint) intersects(k key.Key) bool {
+	ks, ps := k.String(), m.prefix.String()
+	if ps == "/" || ks == "/" {
+		return true
+	}
+	return ks == ps || strings.HasPrefix(ks, ps+"/") || strings.HasPrefix(ps, ks+"/")
+}
+
+// childPrefix translates a query prefix into the child datastore's key
+// space: if the query prefix reaches into the mount, it's stripped like any
+// other key; if the query prefix is an ancestor of the whole mount, the
+// entire mount is in scope.
+func (m *mountpoint) childPrefix(prefix key.Key) key.Key {
+	if m.under(prefix) {
+		return m.toLocal(prefix)
+	}
+	return key.NewKey("/")
+}
+
+// Datastore multiplexes several child datastores across disjoint (or
+// nested) key prefixes, routing each operation to the mount whose prefix is
+// the longest match for the given key.
+type Datastore struct {
+	mounts []*mountpoint
+}
+
+// New returns a Datastore that routes across the given mounts. Longer
+// prefixes take precedence over shorter ones, so nested mounts (e.g. "/a"
+// and "/a/b") route as expected.
+func New(mounts []Mount) *Datastore {
+	points := make([]*mountpoint, 0, len(mounts))
+	for _, m := range mounts {
+		prefix := key.Clean(m.Prefix)
+		points = append(points, &mountpoint{
+			prefix: prefix,
+			t:      keytransform.PrefixTransform{Prefix: prefix},
+			store:  m.Datastore,
+		})
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return len(points[i].prefix.String()) > len(points[j].prefix.String())
+	})
+	return &Datastore{mounts: points}
+}
+
+// lookup returns the mount whose prefix is the longest match for k.
+func (d *Datastore) lookup(k key.Key) (*mountpoint, error) {
+	for _, m := range d.mounts {
+		if m.under(k) {
+			return m, nil
+		}
+	}
+	return nil, ErrNoMount
+}
+
+// Put stores the given value in the mount matching key.
+func (d *Datastore) Put(ctx context.Context, k key.Key, value []byte) error {
+	m, err := d.lookup(k)
+	if err != nil {
+		return err
+	}
+	return m.store.Put(ctx, m.toLocal(k), value)
+}
+
+// Sync syncs every mount whose prefix intersects the given prefix.
+func (d *Datastore) Sync(ctx context.Context, prefix key.Key) error {
+	for _, m := range d.mounts {
+		if !m.intersects(prefix) {
+			continue
+		}
+		if err := m.store.Sync(ctx, m.childPrefix(prefix)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get returns the value for the given key from the mount matching it.
+func (d *Datastore) Get(ctx context.Context, k key.Key) (value []byte, err error) {
+	m, err := d.lookup(k)
+	if err != nil {
+		return nil, err
+	}
+	return m.store.Get(ctx, m.toLocal(k))
+}
+
+// Has returns whether the mount matching the given key has a value for it.
+func (d *Datastore) Has(ctx context.Context, k key.Key) (exists bool, err error) {
+	m, err := d.lookup(k)
+	if err != nil {
+		return false, err
+	}
+	return m.store.Has(ctx, m.toLocal(k))
+}
+
+// GetSize returns the size of the value named by the given key, from the
+// mount matching it.
+func (d *Datastore) GetSize(ctx context.Context, k key.Key) (size int, err error) {
+	m, err := d.lookup(k)
+	if err != nil {
+		return -1, err
+	}
+	return m.store.GetSize(ctx, m.toLocal(k))
+}
+
+// Delete removes the value for the given key from the mount matching it.
+func (d *Datastore) Delete(ctx context.Context, k key.Key) error {
+	m, err := d.lookup(k)
+	if err != nil {
+		return err
+	}
+	return m.store.Delete(ctx, m.toLocal(k))
+}
+
+// Query fans out to every mount intersecting q.Prefix, re-prepends each
+// mount's prefix to the keys it returns, and merges the resulting streams.
+// Orders, Offset, Limit and Filters are applied naively across the merged,
+// re-prefixed stream, while KeysOnly is passed through to each mount's own
+// query: no single mount owns the full ordering
+// across mounts, and Filters in particular reference the global keyspace,
+// which only exists once results have been merged.
+func (d *Datastore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	prefix := key.Clean(q.Prefix)
+
+	var sources []*mountpoint
+	for _, m := range d.mounts {
+		if m.intersects(prefix) {
+			sources = append(sources, m)
+		}
+	}
+
+	childQuery := q
+	childQuery.Orders = nil
+	childQuery.Offset = 0
+	childQuery.Limit = 0
+	// Filters reference keys in the global, prefixed keyspace, but each
+	// mount only ever sees prefix-stripped local keys, so a key-based
+	// filter would be matched against the wrong keyspace if pushed down
+	// untranslated. Let the naive layer apply all filters once results
+	// have been merged and re-prepended below.
+	childQuery.Filters = nil
+
+	idx := 0
+	var cur dsq.Results
+	var active *mountpoint
+
+	next := func() (dsq.Result, bool) {
+		for {
+			if cur == nil {
+				if idx >= len(sources) {
+					return dsq.Result{}, false
+				}
+				active = sources[idx]
+				idx++
+				cq := childQuery
+				cq.Prefix = active.childPrefix(prefix)
+				r, err := active.store.Query(ctx, cq)
+				if err != nil {
+					return dsq.Result{Error: err}, true
+				}
+				cur = r
+			}
+			res, ok := cur.NextSync()
+			if !ok {
+				if err := cur.Close(); err != nil {
+					return dsq.Result{Error: err}, true
+				}
+				cur = nil
+				continue
+			}
+			if res.Error == nil {
+				res.Entry.Key = active.toGlobal(res.Entry.Key)
+			}
+			return res, true
+		}
+	}
+
+	qr := dsq.ResultsFromIterator(q, dsq.Iterator{
+		Next: next,
+		Close: func() error {
+			if cur != nil {
+				return cur.Close()
+			}
+			return nil
+		},
+	})
+	return dsq.NaiveQueryApply(q, qr), nil
+}
+
+func (d *Datastore) Close() error {
+	var firstErr error
+	for _, m := range d.mounts {
+		if err := m.store.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Children implements ds.Shim
+func (d *Datastore) Children() []ds.Datastore {
+	children := make([]ds.Datastore, 0, len(d.mounts))
+	for _, m := range d.mounts {
+		children = append(children, m.store)
+	}
+	return children
+}
+
+// DiskUsage implements the PersistentDatastore interface, summing the disk
+// usage reported by every mount.
+func (d *Datastore) DiskUsage(ctx context.Context) (uint64, error) {
+	var total uint64
+	for _, m := range d.mounts {
+		du, err := ds.DiskUsage(ctx, m.store)
+		if err != nil {
+			return 0, err
+		}
+		total += du
+	}
+	return total, nil
+}
+
+func (d *Datastore) Batch(ctx context.Context) (ds.Batch, error) {
+	return &mountBatch{d: d, ctx: ctx, batches: make(map[*mountpoint]ds.Batch)}, nil
+}
+
+// mountBatch dispatches Put/Delete to the batch of the mount that owns each
+// key, opening child batches lazily.
+type mountBatch struct {
+	d       *Datastore
+	ctx     context.Context
+	batches map[*mountpoint]ds.Batch
+}
+
+func (b *mountBatch) batchFor(k key.Key) (ds.Batch, *mountpoint, error) {
+	m, err := b.d.lookup(k)
+	if err != nil {
+		return nil, nil, err
+	}
+	if bd, ok := b.batches[m]; ok {
+		return bd, m, nil
+	}
+	bds, ok := m.store.(ds.Batching)
+	if !ok {
+		return nil, nil, ds.ErrBatchUnsupported
+	}
+	bd, err := bds.Batch(b.ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	b.batches[m] = bd
+	return bd, m, nil
+}
+
+func (b *mountBatch) Put(ctx context.Context, k key.Key, val []byte) error {
+	bd, m, err := b.batchFor(k)
+	if err != nil {
+		return err
+	}
+	return bd.Put(ctx, m.toLocal(k), val)
+}
+
+func (b *mountBatch) Delete(ctx context.Context, k key.Key) error {
+	bd, m, err := b.batchFor(k)
+	if err != nil {
+		return err
+	}
+	return bd.Delete(ctx, m.toLocal(k))
+}
+
+// Commit commits every child batch that received an operation.
+//
+// This is NOT atomic across mounts: child datastores have no shared
+// transaction coordinator, so there is no way to stage or roll back a
+// commit once it has been applied to a child. If committing one mount
+// fails, Commit still attempts to commit the rest rather than leaving them
+// dangling, and returns the first error encountered; callers that need
+// all-or-nothing semantics across mounts must coordinate that themselves
+// (e.g. by confining a batch to a single mount).
+func (b *mountBatch) Commit(ctx context.Context) error {
+	var firstErr error
+	for _, bd := range b.batches {
+		if err := bd.Commit(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+var _ ds.Batch = (*mountBatch)(nil)
+
+func (d *Datastore) Check(ctx context.Context) error {
+	for _, m := range d.mounts {
+		if c, ok := m.store.(ds.CheckedDatastore); ok {
+			if err := c.Check(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Datastore) Scrub(ctx context.Context) error {
+	for _, m := range d.mounts {
+		if c, ok := m.store.(ds.ScrubbedDatastore); ok {
+			if err := c.Scrub(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Datastore) CollectGarbage(ctx context.Context) error {
+	for _, m := range d.mounts {
+		if c, ok := m.store.(ds.GCDatastore); ok {
+			if err := c.CollectGarbage(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var _ ds.Datastore = (*Datastore)(nil)
+var _ ds.GCDatastore = (*Datastore)(nil)
+var _ ds.Batching = (*Datastore)(nil)
+var _ ds.PersistentDatastore = (*Datastore)(nil)
+var _ ds.ScrubbedDatastore = (*Datastore)(nil)
+var _ ds.CheckedDatastore = (*Datastore)(nil)
@@ -0,0 +1,283 @@
+// Copyright for portions of this fork are held by [Juan Batiz-Benet, 2016]
+// as part of the original go-datastore project. All other copyright for this
+// fork are held by [DAOT Labs, 2020]. All rights reserved. Use of this source
+// code is governed by MIT license that can be found in the LICENSE file.
+
+package mount
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	ds "github.com/daotl/go-datastore"
+	key "github.com/daotl/go-datastore/key"
+	dsq "github.com/daotl/go-datastore/query"
+)
+
+// mapStore is a minimal, non-pushdown-aware in-memory ds.Datastore used to
+// exercise Datastore's routing and merging without depending on a real
+// backend. Its Query applies the incoming query naively, in its own local
+// keyspace, over every entry it holds; a query pushed down untranslated from
+// the global keyspace therefore gives wrong results here, just as it would
+// against a real child datastore.
+type mapStore struct {
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+func newMapStore() *mapStore {
+	return &mapStore{data: make(map[string][]byte)}
+}
+
+func (m *mapStore) Put(ctx context.Context, k key.Key, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.data[k.String()] = value
+	return nil
+}
+
+func (m *mapStore) Sync(ctx context.Context, prefix key.Key) error {
+	return nil
+}
+
+func (m *mapStore) Get(ctx context.Context, k key.Key) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.data[k.String()]
+	if !ok {
+		return nil, ds.ErrNotFound
+	}
+	return v, nil
+}
+
+func (m *mapStore) Has(ctx context.Context, k key.Key) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.data[k.String()]
+	return ok, nil
+}
+
+func (m *mapStore) GetSize(ctx context.Context, k key.Key) (int, error) {
+	v, err := m.Get(ctx, k)
+	if err != nil {
+		return -1, err
+	}
+	return len(v), nil
+}
+
+func (m *mapStore) Delete(ctx context.Context, k key.Key) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.data, k.String())
+	return nil
+}
+
+func (m *mapStore) Query(ctx context.Context, q dsq.Query) (dsq.Results, error) {
+	m.mu.Lock()
+	entries := make([]dsq.Entry, 0, len(m.data))
+	for k, v := range m.data {
+		entries = append(entries, dsq.Entry{Key: key.NewKey(k), Value: v})
+	}
+	m.mu.Unlock()
+	// Apply the query naively over every entry, the same way a real
+	// backing store with no pushdown of its own would: this is what makes
+	// the tests sensitive to a parent incorrectly pushing down filters or
+	// ordering that only make sense in a different keyspace.
+	raw := dsq.ResultsWithEntries(dsq.Query{}, entries)
+	return dsq.NaiveQueryApply(q, raw), nil
+}
+
+func (m *mapStore) Close() error {
+	return nil
+}
+
+var _ ds.Datastore = (*mapStore)(nil)
+
+func collect(t *testing.T, qr dsq.Results) []dsq.Entry {
+	t.Helper()
+	var entries []dsq.Entry
+	for {
+		r, ok := qr.NextSync()
+		if !ok {
+			break
+		}
+		if r.Error != nil {
+			t.Fatal(r.Error)
+		}
+		entries = append(entries, r.Entry)
+	}
+	if err := qr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestPutGetRoutesToLongestMatchingMount(t *testing.T) {
+	a, ab := newMapStore(), newMapStore()
+	d := New([]Mount{
+		{Prefix: key.NewKey("/a"), Datastore: a},
+		{Prefix: key.NewKey("/a/b"), Datastore: ab},
+	})
+	ctx := context.Background()
+
+	if err := d.Put(ctx, key.NewKey("/a/b/x"), []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Put(ctx, key.NewKey("/a/y"), []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, ok := ab.data["/x"]; !ok || string(v) != "v1" {
+		t.Errorf("expected /a/b/x to land in the /a/b mount as local key /x, got %v (present=%v)", v, ok)
+	}
+	if v, ok := a.data["/y"]; !ok || string(v) != "v2" {
+		t.Errorf("expected /a/y to land in the /a mount as local key /y, got %v (present=%v)", v, ok)
+	}
+
+	got, err := d.Get(ctx, key.NewKey("/a/b/x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "v1" {
+		t.Errorf("expected %q, got %q", "v1", got)
+	}
+}
+
+func TestUnmountedKeyReturnsErrNoMount(t *testing.T) {
+	d := New([]Mount{
+		{Prefix: key.NewKey("/a"), Datastore: newMapStore()},
+	})
+	ctx := context.Background()
+
+	if _, err := d.Get(ctx, key.NewKey("/b/x")); err != ErrNoMount {
+		t.Errorf("expected ErrNoMount, got %v", err)
+	}
+	if err := d.Put(ctx, key.NewKey("/b/x"), []byte("v")); err != ErrNoMount {
+		t.Errorf("expected ErrNoMount, got %v", err)
+	}
+	if _, err := d.Has(ctx, key.NewKey("/b/x")); err != ErrNoMount {
+		t.Errorf("expected ErrNoMount, got %v", err)
+	}
+	if err := d.Delete(ctx, key.NewKey("/b/x")); err != ErrNoMount {
+		t.Errorf("expected ErrNoMount, got %v", err)
+	}
+}
+
+func TestQueryMergesAndOrdersAcrossMounts(t *testing.T) {
+	blocks, pins := newMapStore(), newMapStore()
+	d := New([]Mount{
+		{Prefix: key.NewKey("/blocks"), Datastore: blocks},
+		{Prefix: key.NewKey("/pins"), Datastore: pins},
+	})
+	ctx := context.Background()
+
+	for k, v := range map[string]string{
+		"/blocks/b2": "2",
+		"/blocks/b1": "1",
+		"/pins/p1":   "3",
+	} {
+		if err := d.Put(ctx, key.NewKey(k), []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	qr, err := d.Query(ctx, dsq.Query{
+		Orders: []dsq.Order{dsq.OrderByKey{}},
+		Offset: 1,
+		Limit:  1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := collect(t, qr)
+
+	// Global, ordered keys are /blocks/b1, /blocks/b2, /pins/p1; Offset 1,
+	// Limit 1 should select exactly /blocks/b2.
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	if entries[0].Key.String() != "/blocks/b2" {
+		t.Errorf("expected /blocks/b2, got %s", entries[0].Key.String())
+	}
+}
+
+func TestQueryFiltersAreAppliedInGlobalKeyspace(t *testing.T) {
+	blocks := newMapStore()
+	d := New([]Mount{
+		{Prefix: key.NewKey("/blocks"), Datastore: blocks},
+	})
+	ctx := context.Background()
+
+	if err := d.Put(ctx, key.NewKey("/blocks/abc"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+
+	qr, err := d.Query(ctx, dsq.Query{
+		Filters: []dsq.Filter{dsq.FilterKeyPrefix{Prefix: key.NewKey("/blocks")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := collect(t, qr)
+
+	if len(entries) != 1 || entries[0].Key.String() != "/blocks/abc" {
+		t.Fatalf("expected [/blocks/abc] matched against the global prefix, got %v", entries)
+	}
+}
+
+func TestNestedMountsDontDoubleCountQueryResults(t *testing.T) {
+	a, ab := newMapStore(), newMapStore()
+	d := New([]Mount{
+		{Prefix: key.NewKey("/a"), Datastore: a},
+		{Prefix: key.NewKey("/a/b"), Datastore: ab},
+	})
+	ctx := context.Background()
+
+	keys := []string{"/a/x", "/a/b/y", "/a/b/z"}
+	for _, k := range keys {
+		if err := d.Put(ctx, key.NewKey(k), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	qr, err := d.Query(ctx, dsq.Query{Prefix: key.NewKey("/a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := collect(t, qr)
+
+	if len(entries) != len(keys) {
+		t.Fatalf("expected %d entries (no double counting), got %d: %v", len(keys), len(entries), entries)
+	}
+}
+
+func TestMountBatchDispatchesToCorrectChild(t *testing.T) {
+	a, b := newMapStore(), newMapStore()
+	d := New([]Mount{
+		{Prefix: key.NewKey("/a"), Datastore: a},
+		{Prefix: key.NewKey("/b"), Datastore: b},
+	})
+	ctx := context.Background()
+
+	batch, err := d.Batch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Put(ctx, key.NewKey("/a/x"), []byte("va")); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Put(ctx, key.NewKey("/b/y"), []byte("vb")); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, ok := a.data["/x"]; !ok || string(v) != "va" {
+		t.Errorf("expected /a/x to be committed to the /a mount, got %v (present=%v)", v, ok)
+	}
+	if v, ok := b.data["/y"]; !ok || string(v) != "vb" {
+		t.Errorf("expected /b/y to be committed to the /b mount, got %v (present=%v)", v, ok)
+	}
+}